@@ -0,0 +1,141 @@
+package golexer
+
+import "errors"
+
+var UnterminatedCommentError = errors.New("Unterminated block comment.")
+
+// NewBlockCommentParser returns an ErrorTokenParser recognizing "/* ... */"
+// comments and "//"-to-end-of-line comments. Block comments nest (Rust/
+// Swift-style) when tp.NestedBlockComments is set at scan time; otherwise
+// the first "*/" closes the comment (Go-style). When emit is true the full
+// comment text, including delimiters, is returned as a TOKEN_COMMENT;
+// otherwise it is discarded.
+func NewBlockCommentParser(tp *TokenParsers, emit bool) ErrorTokenParser {
+	return func(scanner IRuneScanner, builder ITokenBuilder) (TokenType, error) {
+		if scanner.Rune() != '/' {
+			return TOKEN_NULL, nil
+		}
+		builder.AppendRune()
+
+		switch scanner.NextRune() {
+		case '/':
+			if emit {
+				builder.AppendRune()
+			}
+			for r := scanner.NextRune(); r != 0 && r != '\n'; r = scanner.NextRune() {
+				if emit {
+					builder.AppendRune()
+				}
+			}
+			if !emit {
+				return TOKEN_NULL, nil
+			}
+			return TOKEN_COMMENT, nil
+
+		case '*':
+			if emit {
+				builder.AppendRune()
+			}
+			return scanBlockComment(scanner, builder, tp.NestedBlockComments, emit)
+		}
+
+		// A lone '/' isn't a comment opener in this dialect; report it as an
+		// unknown single-character token rather than losing it.
+		return TOKEN_UNKNOWN, nil
+	}
+}
+
+func scanBlockComment(scanner IRuneScanner, builder ITokenBuilder, nested bool, emit bool) (TokenType, error) {
+	depth := 1
+	r := scanner.NextRune()
+	for {
+		if r == 0 {
+			return TOKEN_COMMENT, UnterminatedCommentError
+		}
+
+		if r == '*' {
+			if emit {
+				builder.AppendRune()
+			}
+			if scanner.NextRune() == '/' {
+				if emit {
+					builder.AppendRune()
+				}
+				r = scanner.NextRune()
+				depth--
+				if depth == 0 {
+					if !emit {
+						return TOKEN_NULL, nil
+					}
+					return TOKEN_COMMENT, nil
+				}
+				continue
+			}
+			r = scanner.Rune()
+			continue
+		}
+
+		if nested && r == '/' {
+			if emit {
+				builder.AppendRune()
+			}
+			if scanner.NextRune() == '*' {
+				if emit {
+					builder.AppendRune()
+				}
+				r = scanner.NextRune()
+				depth++
+				continue
+			}
+			r = scanner.Rune()
+			continue
+		}
+
+		if emit {
+			builder.AppendRune()
+		}
+		r = scanner.NextRune()
+	}
+}
+
+// NewShebangParser returns a TokenParser recognizing a "#!" line, but only
+// when it starts at line 1, column 1 of the input; a '#' anywhere else is
+// left to the other parsers. A '#' at line 1, column 1 that is not followed
+// by '!' is scanned as an ordinary "#" comment right here rather than left
+// to NewCommentParser, since by the time a '#'
+// is known not to start a shebang it has already been consumed. When emit is
+// true the matched line is returned as TOKEN_SHEBANG (or TOKEN_COMMENT for
+// the ordinary-comment fallback); otherwise it is discarded.
+func NewShebangParser(emit bool) TokenParser {
+	return func(scanner IRuneScanner, builder ITokenBuilder) TokenType {
+		pos := scanner.Pos()
+		if pos.Line != 1 || pos.Column != 1 || scanner.Rune() != '#' {
+			return TOKEN_NULL
+		}
+		if emit {
+			builder.AppendRune()
+		}
+
+		isShebang := scanner.NextRune() == '!'
+		if isShebang {
+			if emit {
+				builder.AppendRune()
+			}
+			scanner.NextRune()
+		}
+
+		for r := scanner.Rune(); r != 0 && r != '\n'; r = scanner.NextRune() {
+			if emit {
+				builder.AppendRune()
+			}
+		}
+
+		if !emit {
+			return TOKEN_NULL
+		}
+		if isShebang {
+			return TOKEN_SHEBANG
+		}
+		return TOKEN_COMMENT
+	}
+}
@@ -0,0 +1,69 @@
+package golexer
+
+// bufferedToken pairs a Token with the error that accompanied it when it was
+// scanned, so that a putback or peeked token can be replayed exactly.
+type bufferedToken struct {
+	token Token
+	err   error
+}
+
+// tokenDeque is a slice-backed ring buffer of bufferedToken, used for both
+// PutBack and the Peek/PeekN lookahead cache. Unlike container/list it grows
+// in amortized-O(1) chunks instead of allocating a node per token.
+type tokenDeque struct {
+	buf   []bufferedToken
+	head  int
+	count int
+}
+
+func (d *tokenDeque) Len() int {
+	return d.count
+}
+
+func (d *tokenDeque) PushFront(token bufferedToken) {
+	d.growIfFull()
+	d.head = (d.head - 1 + len(d.buf)) % len(d.buf)
+	d.buf[d.head] = token
+	d.count++
+}
+
+func (d *tokenDeque) PushBack(token bufferedToken) {
+	d.growIfFull()
+	d.buf[(d.head+d.count)%len(d.buf)] = token
+	d.count++
+}
+
+func (d *tokenDeque) PopFront() (bufferedToken, bool) {
+	if d.count == 0 {
+		return bufferedToken{}, false
+	}
+	token := d.buf[d.head]
+	d.head = (d.head + 1) % len(d.buf)
+	d.count--
+	return token, true
+}
+
+// At returns the i'th token from the front (0 is the next token PopFront
+// would return) without removing it.
+func (d *tokenDeque) At(i int) (bufferedToken, bool) {
+	if i < 0 || i >= d.count {
+		return bufferedToken{}, false
+	}
+	return d.buf[(d.head+i)%len(d.buf)], true
+}
+
+func (d *tokenDeque) growIfFull() {
+	if d.count < len(d.buf) {
+		return
+	}
+	newCap := len(d.buf) * 2
+	if newCap == 0 {
+		newCap = 8
+	}
+	newBuf := make([]bufferedToken, newCap)
+	for i := 0; i < d.count; i++ {
+		newBuf[i], _ = d.At(i)
+	}
+	d.buf = newBuf
+	d.head = 0
+}
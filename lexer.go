@@ -1,13 +1,45 @@
 package golexer
 
 import "io"
+import "fmt"
 import "errors"
 import "unicode"
 import "bytes"
-import "container/list"
 
 var NullArgumentError = errors.New("Null argument.")
 var EofError = errors.New("End of file.")
+var UnexpectedTokenError = errors.New("Unexpected token type.")
+
+// byteOrderMark is stripped from the very first rune of the input, following
+// the convention of text/scanner.
+const byteOrderMark = '\uFEFF'
+
+// Position describes a location in the scanned input, following the pattern
+// of text/scanner.Position.
+type Position struct {
+	Filename string // filename, if any
+	Offset   int    // byte offset, starting at 0
+	Line     int    // line number, starting at 1
+	Column   int    // rune column within the line, starting at 1
+}
+
+// IsValid reports whether the position carries a usable line number.
+func (pos Position) IsValid() bool {
+	return pos.Line > 0
+}
+
+// String formats the position as "filename:line:column", matching
+// text/scanner.Position.String.
+func (pos Position) String() string {
+	s := pos.Filename
+	if s == "" {
+		s = "<input>"
+	}
+	if pos.IsValid() {
+		s += fmt.Sprintf(":%d:%d", pos.Line, pos.Column)
+	}
+	return s
+}
 
 type TokenType int
 
@@ -17,23 +49,91 @@ const (
 	TOKEN_IDENTIFIER
 	TOKEN_NUMBER
 	TOKEN_STRING
+	TOKEN_CHAR
+	TOKEN_COMMENT
 	TOKEN_UNKNOWN
+	// TOKEN_ERROR is emitted instead of aborting the scan when
+	// Lexer.RecoverOnError is set and a parser reports an error; see
+	// Token.Err.
+	TOKEN_ERROR
+	// TOKEN_SHEBANG is emitted for a "#!" line at the very start of the
+	// input when NewShebangParser is configured to emit rather than skip it.
+	TOKEN_SHEBANG
+)
+
+// Mode is a set of bit flags that controls which of the default parsers
+// DefaultTokenParsers assembles, following the pattern of text/scanner.Mode.
+type Mode uint
+
+const (
+	ScanIdents Mode = 1 << iota
+	ScanInts
+	ScanFloats
+	ScanChars
+	ScanStrings
+	ScanRawStrings
+	ScanComments
+	SkipComments
+	ScanShebang
 )
 
+// ScanNumbers selects DefaultNumberParser. Like ScanInts or ScanFloats alone,
+// it scans runs of decimal digits only; a fractional part or exponent is not
+// scanned as part of the number.
+const ScanNumbers = ScanInts | ScanFloats
+
+// GoTokens is the Mode used by NewDefaultTokenParsers: identifiers, numbers,
+// escape-decoding strings, and skipped "#", "//" and "/* */" comments.
+const GoTokens = ScanIdents | ScanNumbers | ScanStrings | ScanComments | SkipComments
+
+// TOKEN_USER is the first token type value reserved for consumer-defined
+// token kinds, such as keywords registered via RegisterKeyword, so they
+// never collide with the built-in TOKEN_* constants above.
+const TOKEN_USER TokenType = 1000
+
 type Token struct {
-	Type       TokenType
-	Literal    string
+	Type    TokenType
+	Literal string
+	// Value holds a parser-decoded representation of Literal, e.g. the
+	// unescaped contents of a string literal or a NumberValue. It is nil
+	// unless the parser that produced the token called SetValue.
+	Value interface{}
+	Pos   Position
+
+	// Deprecated: use Pos.Line instead.
 	LineNumber int
-	LinePos    int
+	// Deprecated: use Pos.Column instead.
+	LinePos int
+
+	err error
+}
+
+// Err returns the error a parser reported while producing this token, or nil.
+// It is most useful on a TOKEN_ERROR token produced under
+// Lexer.RecoverOnError, where the surrounding GetToken call itself returns a
+// nil error.
+func (t Token) Err() error {
+	return t.err
 }
 
 type IRuneScanner interface {
 	NextRune() rune
 	Rune() rune
+	// Pos returns the position of the current rune, i.e. the rune Rune()
+	// returns. Parsers that need to know where they are in the input (e.g.
+	// a shebang parser that only fires at line 1, column 1) use this instead
+	// of tracking their own offsets.
+	Pos() Position
 }
 
 type ITokenBuilder interface {
 	AppendRune() bool
+	// TokenLiteral returns the raw text appended to the token so far.
+	TokenLiteral() string
+	// SetValue attaches a decoded representation of the token (see
+	// Token.Value) that parsers can populate instead of, or in addition to,
+	// the raw Literal built via AppendRune.
+	SetValue(value interface{})
 }
 
 // The TokenParser reads the runes from the scanner, recognize the rune and build the token via
@@ -42,15 +142,47 @@ type ITokenBuilder interface {
 // token type. The value of the token should be built and stored in ITokenBuilder.
 type TokenParser func(scanner IRuneScanner, builder ITokenBuilder) TokenType
 
+// ErrorTokenParser is like TokenParser, but can additionally report a parse
+// error (e.g. an invalid number or an unterminated string) without aborting
+// the scan of the rest of the input; see cNumberParser for the originating
+// convention. A TOKEN_NULL, nil result means "no match, try the next
+// parser", matching TokenParser.
+type ErrorTokenParser func(scanner IRuneScanner, builder ITokenBuilder) (TokenType, error)
+
 type TokenParsers struct {
+	// Mode records the flags DefaultTokenParsers was built from, if any. It
+	// is zero for a TokenParsers assembled by hand via NewTokenParsers.
+	Mode            Mode
 	SkipWhitespaces TokenParser
 	Parsers         []TokenParser
+	// ErrorParsers are tried, in order, after Parsers finds no match.
+	ErrorParsers []ErrorTokenParser
+	// Keywords maps identifier literals to a user-defined TokenType (e.g.
+	// "if" -> TOKEN_IF). When an identifier parser matches one of these
+	// literals, GetToken promotes TOKEN_IDENTIFIER to the mapped type.
+	// Populate via RegisterKeyword.
+	Keywords map[string]TokenType
+	// NestedBlockComments controls whether NewBlockCommentParser allows
+	// "/* */" comments to nest (Rust/Swift-style) or not (Go-style, the
+	// default). It is read at scan time, so it can be changed after
+	// DefaultTokenParsers has already wired the parser in.
+	NestedBlockComments bool
+}
+
+// RegisterKeyword promotes literal from TOKEN_IDENTIFIER to tt whenever the
+// lexer matches it as an identifier. tt is typically a consumer-defined
+// constant starting at TOKEN_USER.
+func (tp *TokenParsers) RegisterKeyword(literal string, tt TokenType) {
+	if tp.Keywords == nil {
+		tp.Keywords = make(map[string]TokenType)
+	}
+	tp.Keywords[literal] = tt
 }
 
 func NewTokenParsers(skipWhitespaces TokenParser, parsers ...TokenParser) *TokenParsers {
 	tp := TokenParsers{
-		skipWhitespaces,
-		make([]TokenParser, len(parsers)),
+		SkipWhitespaces: skipWhitespaces,
+		Parsers:         make([]TokenParser, len(parsers)),
 	}
 	for index, parser := range parsers {
 		tp.Parsers[index] = parser
@@ -58,13 +190,46 @@ func NewTokenParsers(skipWhitespaces TokenParser, parsers ...TokenParser) *Token
 	return &tp
 }
 
+// DefaultTokenParsers assembles the built-in parsers selected by mode. The
+// string and block-comment parsers, which can report errors, go into
+// ErrorParsers and so are tried after the other parsers. This lets callers tune
+// the lexer for e.g. JSON (ScanStrings|ScanNumbers), shell-style
+// (ScanComments, no SkipComments), or a custom DSL, without reimplementing
+// each default parser.
+func DefaultTokenParsers(mode Mode) *TokenParsers {
+	tp := NewTokenParsers(DefaultSkipWritespaces)
+	tp.Mode = mode
+
+	if mode&ScanShebang != 0 {
+		// Must run before the other parsers so a leading "#!" is not
+		// mistaken for an ordinary "#" comment.
+		tp.Parsers = append(tp.Parsers, NewShebangParser(mode&SkipComments == 0))
+	}
+	if mode&ScanIdents != 0 {
+		tp.Parsers = append(tp.Parsers, DefaultIdentifierParser)
+	}
+	if mode&(ScanInts|ScanFloats) != 0 {
+		tp.Parsers = append(tp.Parsers, DefaultNumberParser)
+	}
+	if mode&(ScanStrings|ScanChars) != 0 {
+		tp.ErrorParsers = append(tp.ErrorParsers, NewStringParser(StringParserOptions{
+			Escapes:      true,
+			RawStrings:   mode&ScanRawStrings != 0,
+			BlockStrings: true,
+			Chars:        mode&ScanChars != 0,
+		}))
+	}
+	if mode&ScanComments != 0 {
+		emit := mode&SkipComments == 0
+		tp.Parsers = append(tp.Parsers, NewCommentParser(!emit))
+		tp.ErrorParsers = append(tp.ErrorParsers, NewBlockCommentParser(tp, emit))
+	}
+
+	return tp
+}
+
 func NewDefaultTokenParsers() *TokenParsers {
-	return NewTokenParsers(
-		DefaultSkipWritespaces,
-		DefaultIdentifierParser,
-		DefaultNumberParser,
-		DefaultQuotedStringParser,
-		DefaultCommentParser)
+	return DefaultTokenParsers(GoTokens)
 }
 
 func DefaultSkipWritespaces(scanner IRuneScanner, builder ITokenBuilder) TokenType {
@@ -102,6 +267,8 @@ func DefaultNumberParser(scanner IRuneScanner, builder ITokenBuilder) TokenType
 	return TOKEN_NULL
 }
 
+// Deprecated: use NewStringParser instead; DefaultQuotedStringParser copies
+// the string literal verbatim, without decoding escapes.
 func DefaultQuotedStringParser(scanner IRuneScanner, builder ITokenBuilder) TokenType {
 	quotemark := scanner.Rune()
 	if quotemark == '"' || quotemark == '\'' {
@@ -117,32 +284,71 @@ func DefaultQuotedStringParser(scanner IRuneScanner, builder ITokenBuilder) Toke
 	return TOKEN_NULL
 }
 
+// Deprecated: use NewCommentParser(true) instead; DefaultCommentParser always
+// discards the comment it matches.
 func DefaultCommentParser(scanner IRuneScanner, builder ITokenBuilder) TokenType {
-	r := scanner.Rune()
-	if r == '#' {
+	return NewCommentParser(true)(scanner, builder)
+}
+
+// NewCommentParser returns a TokenParser recognizing `#`-to-end-of-line
+// comments. When skip is true the comment is discarded and no token is
+// produced, matching the historical behavior of DefaultCommentParser. When
+// skip is false the full comment text, including the leading `#`, is
+// returned as a TOKEN_COMMENT.
+func NewCommentParser(skip bool) TokenParser {
+	return func(scanner IRuneScanner, builder ITokenBuilder) TokenType {
+		if scanner.Rune() != '#' {
+			return TOKEN_NULL
+		}
+		if !skip {
+			builder.AppendRune()
+		}
 		for scanner.NextRune() != 0 {
 			if scanner.Rune() == '\n' {
 				break
 			}
+			if !skip {
+				builder.AppendRune()
+			}
 		}
+		if skip {
+			return TOKEN_NULL
+		}
+		return TOKEN_COMMENT
 	}
-	return TOKEN_NULL
 }
 
 type Lexer struct {
-	tokenParsers  *TokenParsers
-	scanner       io.RuneScanner
-	lineno        int
-	pos           int
-	lastPos       int
-	r             rune
-	eof           bool
-	buf           bytes.Buffer
-	lastToken     Token
-	putbackTokens *list.List
+	// RecoverOnError makes scan errors (e.g. an invalid number or an
+	// unterminated string) produce a TOKEN_ERROR token instead of aborting
+	// the current GetToken call, so a single pass can report more than one
+	// malformed token.
+	RecoverOnError bool
+
+	tokenParsers *TokenParsers
+	scanner      io.RuneScanner
+	filename     string
+	started      bool
+	offset       int // byte offset of the current rune
+	line         int // 1-based line of the current rune
+	column       int // 1-based rune column of the current rune
+	prevSize     int // byte size of the current rune
+	lastPos      Position
+	r            rune
+	eof          bool
+	buf          bytes.Buffer
+	value        interface{}
+	lastToken    Token
+	pending      tokenDeque // PutBack tokens and the Peek/PeekN lookahead cache
 }
 
 func NewLexer(scanner io.RuneScanner, tokenParsers *TokenParsers) (*Lexer, error) {
+	return NewLexerFromFile("", scanner, tokenParsers)
+}
+
+// NewLexerFromFile creates a Lexer that annotates every token's Position with
+// filename, for use in "filename:line:col: message" style diagnostics.
+func NewLexerFromFile(filename string, scanner io.RuneScanner, tokenParsers *TokenParsers) (*Lexer, error) {
 	if scanner == nil {
 		return nil, NullArgumentError
 	}
@@ -153,28 +359,49 @@ func NewLexer(scanner io.RuneScanner, tokenParsers *TokenParsers) (*Lexer, error
 	lexer := new(Lexer)
 	lexer.tokenParsers = tokenParsers
 	lexer.scanner = scanner
-	lexer.putbackTokens = list.New()
+	lexer.filename = filename
 
-	// read the first rune to kick off the lexer scan process.
+	// read the first rune to kick off the lexer scan process, then strip a
+	// leading byte-order mark if present (as text/scanner does) so it is
+	// never visible to the parsers or counted in any position.
 	lexer.NextRune()
+	if lexer.r == byteOrderMark {
+		lexer.NextRune()
+		lexer.offset = 0
+		lexer.line = 1
+		lexer.column = 1
+	}
 
 	return lexer, nil
 }
 
 func (self *Lexer) NextRune() rune {
-	if !self.eof {
-		r, size, err := self.scanner.ReadRune()
-		if err != nil {
-			r = 0
-			self.eof = true
-		} else if r == '\n' {
-			self.lineno++
-			self.pos = 0
+	if self.eof {
+		return self.r
+	}
+	prevRune := self.r
+	r, size, err := self.scanner.ReadRune()
+	if err != nil {
+		self.r = 0
+		self.eof = true
+		return self.r
+	}
+	if !self.started {
+		self.started = true
+		self.offset = 0
+		self.line = 1
+		self.column = 1
+	} else {
+		self.offset += self.prevSize
+		if prevRune == '\n' {
+			self.line++
+			self.column = 1
 		} else {
-			self.pos += size
+			self.column++
 		}
-		self.r = r
 	}
+	self.prevSize = size
+	self.r = r
 	return self.r
 }
 
@@ -182,60 +409,190 @@ func (self *Lexer) Rune() rune {
 	return self.r
 }
 
+func (self *Lexer) Pos() Position {
+	return Position{
+		Filename: self.filename,
+		Offset:   self.offset,
+		Line:     self.line,
+		Column:   self.column,
+	}
+}
+
 func (self *Lexer) AppendRune() bool {
 	self.buf.WriteRune(self.r)
 	return true
 }
 
+func (self *Lexer) TokenLiteral() string {
+	return self.buf.String()
+}
+
+func (self *Lexer) SetValue(value interface{}) {
+	self.value = value
+}
+
 func (self *Lexer) token(tokenType TokenType) Token {
 	self.lastToken = Token{
 		Type:       tokenType,
 		Literal:    self.buf.String(),
-		LineNumber: self.lineno,
-		LinePos:    self.lastPos,
+		Value:      self.value,
+		Pos:        self.lastPos,
+		LineNumber: self.lastPos.Line,
+		LinePos:    self.lastPos.Column,
 	}
 	return self.lastToken
 }
 
+// tokenWithError is like token, but additionally attaches err to the Token
+// so it is available via Token.Err even when the caller discards the error
+// GetToken/scan returns alongside it.
+func (self *Lexer) tokenWithError(tokenType TokenType, err error) Token {
+	tok := self.token(tokenType)
+	tok.err = err
+	self.lastToken = tok
+	return tok
+}
+
 func (self *Lexer) IsEnd() bool {
 	return self.eof
 }
 
 func (self *Lexer) GetToken() (Token, error) {
-	// Handles the putback tokens first.
-	if self.putbackTokens.Len() > 0 {
-		e := self.putbackTokens.Back()
-		ptoken, _ := e.Value.(*Token)
-		self.lastToken = *ptoken
-		self.putbackTokens.Remove(e)
-		return self.lastToken, nil
+	// Drain any putback or peeked tokens before scanning new input.
+	if buffered, ok := self.pending.PopFront(); ok {
+		self.lastToken = buffered.token
+		return buffered.token, buffered.err
 	}
+	return self.scan()
+}
 
-	// Skip all whitespaces
-	if self.tokenParsers.SkipWhitespaces(self, self) == TOKEN_EOF || self.IsEnd() {
-		return self.token(TOKEN_EOF), EofError
+// Peek returns the next token without consuming it: the following GetToken
+// or Peek call observes the same token again.
+func (self *Lexer) Peek() (Token, error) {
+	return self.PeekN(0)
+}
+
+// PeekN returns the token n positions ahead (PeekN(0) is the same as Peek)
+// without consuming it or any token before it.
+func (self *Lexer) PeekN(n int) (Token, error) {
+	for self.pending.Len() <= n {
+		token, err := self.scan()
+		self.pending.PushBack(bufferedToken{token, err})
+		if err != nil {
+			break
+		}
+	}
+	buffered, ok := self.pending.At(n)
+	if !ok {
+		return self.lastToken, EofError
 	}
+	self.lastToken = buffered.token
+	return buffered.token, buffered.err
+}
 
-	self.lastPos = self.pos - 1
+// Expect consumes the next token and returns it if it has type tt;
+// otherwise it puts the token back and returns UnexpectedTokenError.
+func (self *Lexer) Expect(tt TokenType) (Token, error) {
+	token, err := self.GetToken()
+	if err != nil {
+		return token, err
+	}
+	if token.Type != tt {
+		self.PutBack(token)
+		return token, UnexpectedTokenError
+	}
+	return token, nil
+}
+
+// Accept consumes and returns the next token if it has type tt; otherwise it
+// puts the token back (if one was read) and returns false.
+func (self *Lexer) Accept(tt TokenType) (Token, bool) {
+	token, err := self.GetToken()
+	if err != nil {
+		return token, false
+	}
+	if token.Type != tt {
+		self.PutBack(token)
+		return token, false
+	}
+	return token, true
+}
 
-	// Clear the token buffer.
-	self.buf.Reset()
+// scan reads and returns the next token directly from the input, without
+// consulting the putback/peek buffer. A parser that silently consumes input
+// without producing a token (a skipped comment or shebang line) restarts the
+// scan rather than falling through to the unrecognized-rune case below.
+func (self *Lexer) scan() (Token, error) {
+	for {
+		// Skip all whitespaces
+		if self.tokenParsers.SkipWhitespaces(self, self) == TOKEN_EOF || self.IsEnd() {
+			return self.token(TOKEN_EOF), EofError
+		}
+
+		self.lastPos = self.Pos()
+
+		// Clear the token buffer.
+		self.buf.Reset()
+		self.value = nil
 
-	for _, parser := range self.tokenParsers.Parsers {
-		if parser != nil {
+		consumed := false
+
+		for _, parser := range self.tokenParsers.Parsers {
+			if parser == nil {
+				continue
+			}
+			before := self.offset
 			tokenType := parser(self, self)
 			if tokenType != TOKEN_NULL {
+				if tokenType == TOKEN_IDENTIFIER && self.tokenParsers.Keywords != nil {
+					if kw, ok := self.tokenParsers.Keywords[self.buf.String()]; ok {
+						tokenType = kw
+					}
+				}
 				return self.token(tokenType), nil
 			}
+			if self.offset != before || self.eof {
+				consumed = true
+			}
+		}
+
+		for _, parser := range self.tokenParsers.ErrorParsers {
+			if parser == nil {
+				continue
+			}
+			before := self.offset
+			startRune := self.r
+			tokenType, err := parser(self, self)
+			if tokenType != TOKEN_NULL || err != nil {
+				if err != nil && self.RecoverOnError {
+					// Guarantee forward progress even if the parser
+					// consumed nothing before failing.
+					if self.r == startRune {
+						self.NextRune()
+					}
+					return self.tokenWithError(TOKEN_ERROR, err), nil
+				}
+				return self.tokenWithError(tokenType, err), err
+			}
+			if self.offset != before || self.eof {
+				consumed = true
+			}
 		}
-	}
 
-	// Any unrecognized runes is treated as an unknown token.
-	self.AppendRune()
-	self.NextRune()
-	return self.token(TOKEN_UNKNOWN), nil
+		if consumed {
+			// A parser discarded input it recognized (e.g. a skipped
+			// comment or shebang line); look for the next real token.
+			continue
+		}
+
+		// Any unrecognized runes is treated as an unknown token.
+		self.AppendRune()
+		self.NextRune()
+		return self.token(TOKEN_UNKNOWN), nil
+	}
 }
 
+// PutBack makes token the next token GetToken returns.
 func (self *Lexer) PutBack(token Token) {
-	self.putbackTokens.PushBack(&token)
+	self.pending.PushFront(bufferedToken{token, nil})
 }
@@ -0,0 +1,327 @@
+package golexer
+
+import "errors"
+import "strings"
+
+var InvalidStringError = errors.New("Invalid string escape sequence.")
+var UnterminatedStringError = errors.New("Unterminated string literal.")
+
+// StringParserOptions selects which string syntaxes NewStringParser
+// recognizes.
+type StringParserOptions struct {
+	// Escapes enables C/Go-style backslash escapes (\n \r \t \\ \" \' \0
+	// \xHH \uHHHH \UHHHHHHHH \OOO) inside double- and single-quoted strings.
+	Escapes bool
+	// RawStrings enables backtick-delimited raw strings whose contents,
+	// including newlines, are copied verbatim.
+	RawStrings bool
+	// BlockStrings enables GraphQL-style triple-double-quoted block strings
+	// with automatic common-indent stripping.
+	BlockStrings bool
+	// Chars makes a single-quoted literal, e.g. 'a' or '\n', produce a
+	// TOKEN_CHAR instead of a TOKEN_STRING. When false (the default), a
+	// single-quoted literal is scanned the same as a double-quoted one.
+	Chars bool
+}
+
+// NewStringParser returns an ErrorTokenParser recognizing string literals per
+// opts. The decoded contents are attached to the token via SetValue, while
+// Literal keeps the raw source text including quotes and escapes.
+func NewStringParser(opts StringParserOptions) ErrorTokenParser {
+	return func(scanner IRuneScanner, builder ITokenBuilder) (TokenType, error) {
+		switch scanner.Rune() {
+		case '`':
+			if !opts.RawStrings {
+				return TOKEN_NULL, nil
+			}
+			return scanRawString(scanner, builder)
+
+		case '"', '\'':
+			quote := scanner.Rune()
+			tokenType := TOKEN_STRING
+			if quote == '\'' && opts.Chars {
+				tokenType = TOKEN_CHAR
+			}
+			builder.AppendRune()
+			r := scanner.NextRune()
+
+			if opts.BlockStrings && quote == '"' && r == '"' {
+				builder.AppendRune()
+				if scanner.NextRune() == '"' {
+					builder.AppendRune()
+					scanner.NextRune()
+					return scanBlockString(scanner, builder)
+				}
+				// Two quotes followed by a non-quote rune is just an empty string.
+				builder.SetValue("")
+				return TOKEN_STRING, nil
+			}
+
+			return scanQuotedString(scanner, builder, quote, tokenType, opts.Escapes)
+		}
+		return TOKEN_NULL, nil
+	}
+}
+
+func scanQuotedString(scanner IRuneScanner, builder ITokenBuilder, quote rune, tokenType TokenType, escapes bool) (TokenType, error) {
+	var value strings.Builder
+	r := scanner.Rune()
+	for {
+		if r == 0 {
+			return tokenType, UnterminatedStringError
+		}
+		if r == quote {
+			builder.AppendRune()
+			scanner.NextRune()
+			break
+		}
+		if r == '\n' {
+			return tokenType, UnterminatedStringError
+		}
+		if escapes && r == '\\' {
+			builder.AppendRune()
+			decoded, next, err := decodeEscape(scanner, builder)
+			if err != nil {
+				return tokenType, err
+			}
+			value.WriteString(decoded)
+			r = next
+			continue
+		}
+		builder.AppendRune()
+		value.WriteRune(r)
+		r = scanner.NextRune()
+	}
+	builder.SetValue(value.String())
+	return tokenType, nil
+}
+
+func scanRawString(scanner IRuneScanner, builder ITokenBuilder) (TokenType, error) {
+	builder.AppendRune() // opening backtick
+	var value strings.Builder
+	r := scanner.NextRune()
+	for {
+		if r == 0 {
+			return TOKEN_STRING, UnterminatedStringError
+		}
+		if r == '`' {
+			builder.AppendRune()
+			scanner.NextRune()
+			break
+		}
+		builder.AppendRune()
+		value.WriteRune(r)
+		r = scanner.NextRune()
+	}
+	builder.SetValue(value.String())
+	return TOKEN_STRING, nil
+}
+
+// scanBlockString scans the body of a GraphQL-style triple-quoted string; the
+// opening """ has already been consumed by the caller.
+func scanBlockString(scanner IRuneScanner, builder ITokenBuilder) (TokenType, error) {
+	var raw strings.Builder
+	quoteRun := 0
+	r := scanner.Rune()
+	for {
+		if r == 0 {
+			return TOKEN_STRING, UnterminatedStringError
+		}
+
+		if r == '"' {
+			builder.AppendRune()
+			quoteRun++
+			if quoteRun == 3 {
+				scanner.NextRune()
+				builder.SetValue(dedentBlockString(raw.String()))
+				return TOKEN_STRING, nil
+			}
+			r = scanner.NextRune()
+			continue
+		}
+		for ; quoteRun > 0; quoteRun-- {
+			raw.WriteByte('"')
+		}
+
+		if r == '\\' {
+			builder.AppendRune()
+			next := scanner.NextRune()
+			if next == '"' {
+				// \""" escapes a literal """ inside the block string.
+				builder.AppendRune()
+				quotes := 1
+				r = scanner.NextRune()
+				for quotes < 3 && r == '"' {
+					builder.AppendRune()
+					quotes++
+					r = scanner.NextRune()
+				}
+				if quotes < 3 {
+					raw.WriteByte('\\')
+				}
+				raw.WriteString(strings.Repeat("\"", quotes))
+				continue
+			}
+			raw.WriteByte('\\')
+			r = next
+			continue
+		}
+
+		builder.AppendRune()
+		raw.WriteRune(r)
+		r = scanner.NextRune()
+	}
+}
+
+// dedentBlockString implements the GraphQL block string value algorithm:
+// find the minimum indent across all lines but the first, strip that many
+// leading spaces/tabs from each of those lines, then drop any leading or
+// trailing lines that are entirely blank.
+func dedentBlockString(raw string) string {
+	lines := strings.Split(raw, "\n")
+
+	indent := -1
+	for _, line := range lines[1:] {
+		n := leadingWhitespace(line)
+		if n == len(line) {
+			continue // blank line; does not participate in the indent calc
+		}
+		if indent == -1 || n < indent {
+			indent = n
+		}
+	}
+	if indent > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) >= indent {
+				lines[i] = lines[i][indent:]
+			} else {
+				lines[i] = ""
+			}
+		}
+	}
+
+	for len(lines) > 0 && isBlankLine(lines[0]) {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && isBlankLine(lines[len(lines)-1]) {
+		lines = lines[:len(lines)-1]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func leadingWhitespace(line string) int {
+	n := 0
+	for n < len(line) && (line[n] == ' ' || line[n] == '\t') {
+		n++
+	}
+	return n
+}
+
+func isBlankLine(line string) bool {
+	return leadingWhitespace(line) == len(line)
+}
+
+// decodeEscape decodes a backslash escape sequence, assuming scanner.Rune()
+// is the backslash itself (already appended to the token by the caller). It
+// returns the decoded text (a single byte for \xHH/\OOO, a code point for
+// everything else, matching Go's own escape semantics) and the scanner rune
+// immediately following the escape sequence.
+func decodeEscape(scanner IRuneScanner, builder ITokenBuilder) (string, rune, error) {
+	r := scanner.NextRune()
+	switch r {
+	case 'n':
+		builder.AppendRune()
+		return "\n", scanner.NextRune(), nil
+	case 'r':
+		builder.AppendRune()
+		return "\r", scanner.NextRune(), nil
+	case 't':
+		builder.AppendRune()
+		return "\t", scanner.NextRune(), nil
+	case '\\':
+		builder.AppendRune()
+		return "\\", scanner.NextRune(), nil
+	case '"':
+		builder.AppendRune()
+		return "\"", scanner.NextRune(), nil
+	case '\'':
+		builder.AppendRune()
+		return "'", scanner.NextRune(), nil
+	case 'x':
+		builder.AppendRune()
+		return decodeByteEscape(scanner, builder)
+	case 'u':
+		builder.AppendRune()
+		value, next, err := decodeHexEscape(scanner, builder, 4)
+		return string(value), next, err
+	case 'U':
+		builder.AppendRune()
+		value, next, err := decodeHexEscape(scanner, builder, 8)
+		return string(value), next, err
+	}
+	if isOctDigit(r) {
+		return decodeOctalEscape(scanner, builder, r)
+	}
+	return "", 0, InvalidStringError
+}
+
+// decodeHexEscape decodes the n hex digits of a \uHHHH or \UHHHHHHHH escape
+// to the code point they name.
+func decodeHexEscape(scanner IRuneScanner, builder ITokenBuilder, n int) (rune, rune, error) {
+	value := 0
+	r := scanner.NextRune()
+	for i := 0; i < n; i++ {
+		if !isHexDigit(r) {
+			return 0, 0, InvalidStringError
+		}
+		value = value*16 + hexDigitValue(r)
+		builder.AppendRune()
+		r = scanner.NextRune()
+	}
+	return rune(value), r, nil
+}
+
+// decodeByteEscape decodes the 2 hex digits of a \xHH escape to a single raw
+// byte; unlike \uHHHH/\UHHHHHHHH, Go's \xHH names a byte, not a code point,
+// so values >= 0x80 must not be re-encoded as UTF-8.
+func decodeByteEscape(scanner IRuneScanner, builder ITokenBuilder) (string, rune, error) {
+	value := 0
+	r := scanner.NextRune()
+	for i := 0; i < 2; i++ {
+		if !isHexDigit(r) {
+			return "", 0, InvalidStringError
+		}
+		value = value*16 + hexDigitValue(r)
+		builder.AppendRune()
+		r = scanner.NextRune()
+	}
+	return string([]byte{byte(value)}), r, nil
+}
+
+// decodeOctalEscape decodes \OOO, where first is the digit immediately after
+// the backslash and up to two further octal digits may follow. Like \xHH,
+// this is a byte escape, not a code point.
+func decodeOctalEscape(scanner IRuneScanner, builder ITokenBuilder, first rune) (string, rune, error) {
+	value := int(first - '0')
+	builder.AppendRune()
+	r := scanner.NextRune()
+	for i := 0; i < 2 && isOctDigit(r); i++ {
+		value = value*8 + int(r-'0')
+		builder.AppendRune()
+		r = scanner.NextRune()
+	}
+	return string([]byte{byte(value)}), r, nil
+}
+
+func hexDigitValue(r rune) int {
+	switch {
+	case r >= '0' && r <= '9':
+		return int(r - '0')
+	case r >= 'a' && r <= 'f':
+		return int(r-'a') + 10
+	case r >= 'A' && r <= 'F':
+		return int(r-'A') + 10
+	}
+	return 0
+}
@@ -0,0 +1,50 @@
+package golexer
+
+import "strings"
+import "testing"
+
+func TestStringParserHexEscapes(t *testing.T) {
+	cases := []struct {
+		source string
+		want   string
+	}{
+		{"\"\\x41\"", "A"},
+		{"\"\\u0041\"", "A"},
+		{"\"\\U00000041\"", "A"},
+		{"\"\\xFF\"", "\xff"},
+	}
+
+	for _, c := range cases {
+		lexer, err := NewLexer(strings.NewReader(c.source), nil)
+		if err != nil {
+			t.Fatalf("%s: cannot create a lexer: %s", c.source, err.Error())
+		}
+		token, err := lexer.GetToken()
+		if err != nil {
+			t.Fatalf("%s: GetToken returned an error: %s", c.source, err.Error())
+		}
+		if token.Type != TOKEN_STRING {
+			t.Fatalf("%s: got token type %v, want TOKEN_STRING", c.source, token.Type)
+		}
+		if token.Value != c.want {
+			t.Errorf("%s: got value %q, want %q", c.source, token.Value, c.want)
+		}
+	}
+}
+
+func TestStringParserScanChars(t *testing.T) {
+	lexer, err := NewLexer(strings.NewReader(`'a'`), DefaultTokenParsers(GoTokens|ScanChars))
+	if err != nil {
+		t.Fatalf("cannot create a lexer: %s", err.Error())
+	}
+	token, err := lexer.GetToken()
+	if err != nil {
+		t.Fatalf("GetToken returned an error: %s", err.Error())
+	}
+	if token.Type != TOKEN_CHAR {
+		t.Fatalf("got token type %v, want TOKEN_CHAR", token.Type)
+	}
+	if token.Value != "a" {
+		t.Errorf("got value %q, want %q", token.Value, "a")
+	}
+}